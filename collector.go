@@ -0,0 +1,867 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
+)
+
+// alwaysOnLabels are the labels plex_media_items_count_total/bytes_total have
+// always carried. They are not gated by --labels, so upgrading the collector
+// without touching flags doesn't silently drop them from existing
+// dashboards/alerts.
+var alwaysOnLabels = []string{
+	"audio_channels",
+	"audio_codec",
+	"video_codec",
+	"video_resolution",
+}
+
+// allowableLabels is the set of additional labels that can be opted into via
+// --labels. "media_type" and alwaysOnLabels are always included and cannot
+// be disabled.
+var allowableLabels = []string{
+	"bitrate_bucket",
+	"container",
+	"hdr",
+	"library",
+	"section_id",
+}
+
+// bitrateBucket buckets a size-derived bitrate into coarse ranges so that
+// --labels=bitrate_bucket doesn't introduce unbounded cardinality.
+func bitrateBucket(bitsPerSecond int) string {
+	switch {
+	case bitsPerSecond <= 0:
+		return "unknown"
+	case bitsPerSecond < 2_000_000:
+		return "<2mbps"
+	case bitsPerSecond < 5_000_000:
+		return "2-5mbps"
+	case bitsPerSecond < 10_000_000:
+		return "5-10mbps"
+	case bitsPerSecond < 20_000_000:
+		return "10-20mbps"
+	default:
+		return ">=20mbps"
+	}
+}
+
+type MediaItem struct {
+	id                   int
+	audioChannels        int
+	audioCodec           string
+	bitrate              int
+	container            string
+	grandParentRatingKey string
+	hdr                  bool
+	libraryName          string
+	mediaType            string
+	parentRatingKey      string
+	sectionKey           string
+	size                 int
+	videoCodec           string
+	videoResolution      string
+}
+
+// mediaItemJSON mirrors MediaItem with exported fields, since encoding/json
+// can't see MediaItem's unexported ones. It's only used for persistence in
+// the Store.
+type mediaItemJSON struct {
+	ID                   int    `json:"id"`
+	AudioChannels        int    `json:"audio_channels"`
+	AudioCodec           string `json:"audio_codec"`
+	Bitrate              int    `json:"bitrate"`
+	Container            string `json:"container"`
+	GrandParentRatingKey string `json:"grandparent_rating_key"`
+	HDR                  bool   `json:"hdr"`
+	LibraryName          string `json:"library_name"`
+	MediaType            string `json:"media_type"`
+	ParentRatingKey      string `json:"parent_rating_key"`
+	SectionKey           string `json:"section_key"`
+	Size                 int    `json:"size"`
+	VideoCodec           string `json:"video_codec"`
+	VideoResolution      string `json:"video_resolution"`
+}
+
+func (m MediaItem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(mediaItemJSON{
+		ID:                   m.id,
+		AudioChannels:        m.audioChannels,
+		AudioCodec:           m.audioCodec,
+		Bitrate:              m.bitrate,
+		Container:            m.container,
+		GrandParentRatingKey: m.grandParentRatingKey,
+		HDR:                  m.hdr,
+		LibraryName:          m.libraryName,
+		MediaType:            m.mediaType,
+		ParentRatingKey:      m.parentRatingKey,
+		SectionKey:           m.sectionKey,
+		Size:                 m.size,
+		VideoCodec:           m.videoCodec,
+		VideoResolution:      m.videoResolution,
+	})
+}
+
+func (m *MediaItem) UnmarshalJSON(data []byte) error {
+	var aux mediaItemJSON
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	*m = MediaItem{
+		id:                   aux.ID,
+		audioChannels:        aux.AudioChannels,
+		audioCodec:           aux.AudioCodec,
+		bitrate:              aux.Bitrate,
+		container:            aux.Container,
+		grandParentRatingKey: aux.GrandParentRatingKey,
+		hdr:                  aux.HDR,
+		libraryName:          aux.LibraryName,
+		mediaType:            aux.MediaType,
+		parentRatingKey:      aux.ParentRatingKey,
+		sectionKey:           aux.SectionKey,
+		size:                 aux.Size,
+		videoCodec:           aux.VideoCodec,
+		videoResolution:      aux.VideoResolution,
+	}
+
+	return nil
+}
+
+func (m *MediaItem) labels(allowed map[string]bool) prometheus.Labels {
+	l := prometheus.Labels{
+		"media_type":       m.mediaType,
+		"audio_channels":   strconv.Itoa(m.audioChannels),
+		"audio_codec":      m.audioCodec,
+		"video_codec":      m.videoCodec,
+		"video_resolution": m.videoResolution,
+	}
+
+	if allowed["bitrate_bucket"] {
+		l["bitrate_bucket"] = bitrateBucket(m.bitrate)
+	}
+
+	if allowed["container"] {
+		l["container"] = m.container
+	}
+
+	if allowed["hdr"] {
+		l["hdr"] = strconv.FormatBool(m.hdr)
+	}
+
+	if allowed["library"] {
+		l["library"] = m.libraryName
+	}
+
+	if allowed["section_id"] {
+		l["section_id"] = m.sectionKey
+	}
+
+	return l
+}
+
+func labelNames(allowed map[string]bool) []string {
+	names := append([]string{"media_type"}, alwaysOnLabels...)
+
+	for _, name := range allowableLabels {
+		if allowed[name] {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+func parseAllowedLabels(raw []string) map[string]bool {
+	allowed := make(map[string]bool, len(raw))
+
+	for _, name := range raw {
+		allowed[name] = true
+	}
+
+	return allowed
+}
+
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+
+	for _, value := range values {
+		set[value] = true
+	}
+
+	return set
+}
+
+// wanted reports whether a section or playlist named name should be
+// collected, given its allow-list (nil/empty means "collect everything")
+// and deny-list, which always takes precedence.
+func wanted(name string, include, exclude map[string]bool) bool {
+	if exclude[name] {
+		return false
+	}
+
+	if len(include) == 0 {
+		return true
+	}
+
+	return include[name]
+}
+
+// CollectorConfig holds the user-configurable knobs for a Collector.
+type CollectorConfig struct {
+	CacheTTL         time.Duration
+	Labels           []string
+	IncludeSections  []string
+	ExcludeSections  []string
+	IncludePlaylists []string
+	Concurrency      int
+	RequestTimeout   time.Duration
+	Store            Store
+}
+
+// Collector is a prometheus.Collector that scrapes Plex on demand. Each call
+// to Collect either serves a cached snapshot, when it is younger than
+// cacheTTL, or performs a fresh scrape and caches the result. This keeps the
+// exported gauges and the in-memory view of Plex's state from ever drifting
+// apart, since there is no longer any incremental Inc/Dec bookkeeping to get
+// out of sync.
+type Collector struct {
+	client         plexAPI
+	cacheTTL       time.Duration
+	allowed        map[string]bool
+	concurrency    int
+	requestTimeout time.Duration
+
+	includeSections  map[string]bool
+	excludeSections  map[string]bool
+	includePlaylists map[string]bool
+
+	store Store
+
+	mu            sync.Mutex
+	mediaItems    []*MediaItem
+	lastScrape    time.Time
+	lastSuccess   time.Time
+	cachedMetrics []prometheus.Metric
+	lastErr       error
+
+	// forceRefresh is set by the webhook handler to invalidate the cache.
+	// It's a lock-free flag rather than a field under mu so that a webhook
+	// POST never blocks on the same mutex a multi-minute scrape holds.
+	forceRefresh atomic.Bool
+
+	itemsCountDesc        *prometheus.Desc
+	itemsBytesDesc        *prometheus.Desc
+	sessionsActiveDesc    *prometheus.Desc
+	transcodeSessionsDesc *prometheus.Desc
+	playlistItemsDesc     *prometheus.Desc
+	playlistBytesDesc     *prometheus.Desc
+	scrapeDuration        prometheus.Histogram
+	lastSuccessGauge      prometheus.Gauge
+	errorsTotal           prometheus.Counter
+	inflightRequests      prometheus.Gauge
+	stateLoadedItems      prometheus.Gauge
+
+	mediaEventsTotal       *prometheus.CounterVec
+	playbackSessionsActive *prometheus.GaugeVec
+}
+
+func NewCollector(client plexAPI, config CollectorConfig) *Collector {
+	allowed := parseAllowedLabels(config.Labels)
+	names := labelNames(allowed)
+
+	concurrency := config.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	store := config.Store
+	if store == nil {
+		store = newMemoryStore()
+	}
+
+	c := &Collector{
+		client:         client,
+		cacheTTL:       config.CacheTTL,
+		allowed:        allowed,
+		concurrency:    concurrency,
+		requestTimeout: config.RequestTimeout,
+		store:          store,
+
+		includeSections:  toSet(config.IncludeSections),
+		excludeSections:  toSet(config.ExcludeSections),
+		includePlaylists: toSet(config.IncludePlaylists),
+
+		itemsCountDesc: prometheus.NewDesc(
+			"plex_media_items_count_total",
+			"The total count of media items.",
+			names, nil,
+		),
+		itemsBytesDesc: prometheus.NewDesc(
+			"plex_media_items_bytes_total",
+			"The total bytes size of media items.",
+			names, nil,
+		),
+		sessionsActiveDesc: prometheus.NewDesc(
+			"plex_sessions_active",
+			"The number of active playback sessions.",
+			nil, nil,
+		),
+		transcodeSessionsDesc: prometheus.NewDesc(
+			"plex_transcode_sessions_active",
+			"The number of active transcode sessions.",
+			[]string{"reason", "throttled"}, nil,
+		),
+		playlistItemsDesc: prometheus.NewDesc(
+			"plex_playlist_items_count",
+			"The number of items in a playlist.",
+			[]string{"playlist", "type"}, nil,
+		),
+		playlistBytesDesc: prometheus.NewDesc(
+			"plex_playlist_items_bytes",
+			"The total bytes size of items in a playlist.",
+			[]string{"playlist", "type"}, nil,
+		),
+		scrapeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "plex_collector_scrape_duration_seconds",
+			Help: "Time spent scraping the Plex Media Server.",
+		}),
+		lastSuccessGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "plex_collector_last_success_timestamp_seconds",
+			Help: "Unix timestamp of the last successful scrape.",
+		}),
+		errorsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "plex_collector_errors_total",
+			Help: "The total number of failed scrapes.",
+		}),
+		inflightRequests: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "plex_collector_inflight_requests",
+			Help: "The number of Plex API requests currently in flight.",
+		}),
+		stateLoadedItems: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "plex_collector_state_loaded_items",
+			Help: "The number of media items restored from the state store at startup.",
+		}),
+		mediaEventsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "plex_media_events_total",
+			Help: "The total number of Plex webhook events received.",
+		}, []string{"event", "library"}),
+		playbackSessionsActive: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "plex_playback_sessions_active",
+			Help: "Active playback sessions reported via the Plex webhook.",
+		}, []string{"user", "player", "media_type"}),
+	}
+
+	if state, err := store.Load(); err != nil {
+		slog.Error("failed to load persisted state", "error", err)
+	} else if len(state.MediaItems) > 0 {
+		c.mediaItems = state.MediaItems
+		c.lastScrape = state.LastRun
+		c.lastSuccess = state.LastRun
+		c.cachedMetrics = c.buildMetrics(scrapeResult{items: state.MediaItems})
+		c.stateLoadedItems.Set(float64(len(state.MediaItems)))
+	}
+
+	return c
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.itemsCountDesc
+	ch <- c.itemsBytesDesc
+	ch <- c.sessionsActiveDesc
+	ch <- c.transcodeSessionsDesc
+	ch <- c.playlistItemsDesc
+	ch <- c.playlistBytesDesc
+	c.scrapeDuration.Describe(ch)
+	c.lastSuccessGauge.Describe(ch)
+	c.errorsTotal.Describe(ch)
+	c.inflightRequests.Describe(ch)
+	c.stateLoadedItems.Describe(ch)
+	c.mediaEventsTotal.Describe(ch)
+	c.playbackSessionsActive.Describe(ch)
+}
+
+// Refresh forces a full reconciliation with Plex, bypassing the cache TTL.
+// It backs the poll-interval ticker, which remains as a fallback in case
+// webhook events are missed or webhooks are not configured.
+func (c *Collector) Refresh() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refresh()
+}
+
+// Ready reports whether the collector is fit to serve traffic: it must have
+// completed a successful scrape within maxAge, and Plex must still be
+// reachable. If the most recent scrape failed, its error is folded into the
+// returned error so /readyz explains why, rather than just reporting the age.
+func (c *Collector) Ready(ctx context.Context, maxAge time.Duration) error {
+	c.mu.Lock()
+	lastSuccess := c.lastSuccess
+	lastErr := c.lastErr
+	c.mu.Unlock()
+
+	if lastSuccess.IsZero() {
+		if lastErr != nil {
+			return fmt.Errorf("no successful scrape yet: %w", lastErr)
+		}
+
+		return fmt.Errorf("no successful scrape yet")
+	}
+
+	if age := time.Since(lastSuccess); age > maxAge {
+		if lastErr != nil {
+			return fmt.Errorf("last successful scrape was %s ago, want at most %s: %w", age, maxAge, lastErr)
+		}
+
+		return fmt.Errorf("last successful scrape was %s ago, want at most %s", age, maxAge)
+	}
+
+	return c.client.Ping(ctx)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cachedMetrics == nil || time.Since(c.lastScrape) >= c.cacheTTL || c.forceRefresh.Load() {
+		c.refresh()
+	}
+
+	for _, metric := range c.cachedMetrics {
+		ch <- metric
+	}
+
+	c.scrapeDuration.Collect(ch)
+	c.lastSuccessGauge.Collect(ch)
+	c.errorsTotal.Collect(ch)
+	c.inflightRequests.Collect(ch)
+	c.stateLoadedItems.Collect(ch)
+	c.mediaEventsTotal.Collect(ch)
+	c.playbackSessionsActive.Collect(ch)
+}
+
+// refresh performs a full scrape of Plex and rebuilds the cached metric
+// snapshot. Callers must hold c.mu.
+func (c *Collector) refresh() {
+	start := time.Now()
+	ctx := context.Background()
+
+	c.forceRefresh.Store(false)
+
+	mediaItems, err := c.scrape(ctx)
+
+	if err == nil {
+		err = c.appendActivityMetrics(ctx, &mediaItems)
+	}
+
+	c.scrapeDuration.Observe(time.Since(start).Seconds())
+	c.lastScrape = start
+
+	if err != nil {
+		c.errorsTotal.Inc()
+		c.lastErr = err
+		slog.Error("scrape failed", "error", err)
+		return
+	}
+
+	c.mediaItems = mediaItems.items
+	c.lastSuccess = start
+	c.lastSuccessGauge.Set(float64(start.Unix()))
+	c.lastErr = nil
+	c.cachedMetrics = c.buildMetrics(mediaItems)
+
+	if err := c.store.Save(StoredState{MediaItems: c.mediaItems, LastRun: c.lastSuccess}); err != nil {
+		slog.Error("failed to persist state", "error", err)
+	}
+}
+
+// scrapeResult bundles everything a scrape gathers: the flattened media
+// items used for the count/bytes gauges, plus the activity data (sessions,
+// transcodes, playlists) used for the newer, non-library gauges.
+type scrapeResult struct {
+	items             []*MediaItem
+	sessionCount      int
+	transcodeSessions []TranscodeSession
+	playlists         map[string]playlistStats
+}
+
+// playlistStats is the per-playlist tally behind the plex_playlist_items_*
+// gauges.
+type playlistStats struct {
+	count int
+	bytes int
+	typ   string
+}
+
+// itemSize sums the on-disk size of every non-deleted Media part backing an
+// Item, across however many Media versions it has.
+func itemSize(item Item) int {
+	size := 0
+
+	for _, media := range item.Media {
+		if media.DeletedAt != 0 {
+			continue
+		}
+
+		for _, part := range media.Parts {
+			size += part.Size
+		}
+	}
+
+	return size
+}
+
+// semaphore bounds the number of concurrent Plex API calls a scrape may have
+// in flight, across both the per-library pool and the nested show/season
+// child-fetch pool.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	return make(semaphore, n)
+}
+
+func (s semaphore) acquire() { s <- struct{}{} }
+func (s semaphore) release() { <-s }
+
+// call runs fn against a context bounded by c.requestTimeout, tracking it in
+// the inflightRequests gauge for the duration. A hung Plex API can only ever
+// stall up to requestTimeout, not the whole scrape.
+func (c *Collector) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	if c.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.requestTimeout)
+		defer cancel()
+	}
+
+	c.inflightRequests.Inc()
+	defer c.inflightRequests.Dec()
+
+	return fn(ctx)
+}
+
+func (c *Collector) scrape(ctx context.Context) (scrapeResult, error) {
+	var libraries []Library
+
+	err := c.call(ctx, func(ctx context.Context) error {
+		var err error
+		libraries, err = c.client.GetLibraries(ctx)
+		return err
+	})
+
+	if err != nil {
+		return scrapeResult{}, err
+	}
+
+	sem := newSemaphore(c.concurrency)
+	group, groupCtx := errgroup.WithContext(ctx)
+
+	var mu sync.Mutex
+	newMediaItems := make([]*MediaItem, 0)
+
+	previousBySection := make(map[string][]*MediaItem, len(c.mediaItems))
+
+	for _, item := range c.mediaItems {
+		previousBySection[item.sectionKey] = append(previousBySection[item.sectionKey], item)
+	}
+
+	for _, library := range libraries {
+		if !wanted(library.Title, c.includeSections, c.excludeSections) {
+			continue
+		}
+
+		if library.UpdatedAt.Before(c.lastSuccess) {
+			// Nothing has changed in this section since the last successful
+			// scrape, so carry its media items forward instead of walking
+			// it again.
+			newMediaItems = append(newMediaItems, previousBySection[library.Key]...)
+			continue
+		}
+
+		library := library
+
+		group.Go(func() error {
+			var items []Item
+
+			sem.acquire()
+			err := c.call(groupCtx, func(ctx context.Context) error {
+				var err error
+				items, err = c.client.GetLibraryItems(ctx, library.Key)
+				return err
+			})
+			sem.release()
+
+			if err != nil {
+				return err
+			}
+
+			mediaItems, err := c.analyzeItems(groupCtx, sem, items, library.Title, library.Key)
+
+			if err != nil {
+				return err
+			}
+
+			mu.Lock()
+			newMediaItems = append(newMediaItems, mediaItems...)
+			mu.Unlock()
+
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return scrapeResult{}, err
+	}
+
+	return scrapeResult{items: newMediaItems}, nil
+}
+
+// appendActivityMetrics fills in the session, transcode and playlist data on
+// an otherwise-complete scrapeResult.
+func (c *Collector) appendActivityMetrics(ctx context.Context, result *scrapeResult) error {
+	var sessions []Session
+
+	if err := c.call(ctx, func(ctx context.Context) error {
+		var err error
+		sessions, err = c.client.GetSessions(ctx)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	result.sessionCount = len(sessions)
+
+	var transcodeSessions []TranscodeSession
+
+	if err := c.call(ctx, func(ctx context.Context) error {
+		var err error
+		transcodeSessions, err = c.client.GetTranscodeSessions(ctx)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	result.transcodeSessions = transcodeSessions
+
+	var playlists []Playlist
+
+	if err := c.call(ctx, func(ctx context.Context) error {
+		var err error
+		playlists, err = c.client.GetPlaylists(ctx)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	result.playlists = make(map[string]playlistStats, len(playlists))
+
+	for _, playlist := range playlists {
+		if !wanted(playlist.Title, c.includePlaylists, nil) {
+			continue
+		}
+
+		var items []Item
+
+		if err := c.call(ctx, func(ctx context.Context) error {
+			var err error
+			items, err = c.client.GetPlaylistItems(ctx, playlist.Key)
+			return err
+		}); err != nil {
+			return err
+		}
+
+		bytes := 0
+
+		for _, item := range items {
+			bytes += itemSize(item)
+		}
+
+		result.playlists[playlist.Title] = playlistStats{count: len(items), bytes: bytes, typ: playlist.Type}
+	}
+
+	return nil
+}
+
+func (c *Collector) buildMetrics(result scrapeResult) []prometheus.Metric {
+	type aggregate struct {
+		count int
+		bytes int
+	}
+
+	aggregates := make(map[string]*aggregate)
+	labelSets := make(map[string]prometheus.Labels)
+
+	for _, item := range result.items {
+		l := item.labels(c.allowed)
+		key := fmt.Sprintf("%v", l)
+
+		agg, ok := aggregates[key]
+		if !ok {
+			agg = &aggregate{}
+			aggregates[key] = agg
+			labelSets[key] = l
+		}
+
+		agg.count++
+		agg.bytes += item.size
+	}
+
+	names := labelNames(c.allowed)
+	metrics := make([]prometheus.Metric, 0, len(aggregates)*2+len(result.transcodeSessions)+len(result.playlists)*2+1)
+
+	for key, agg := range aggregates {
+		l := labelSets[key]
+		values := make([]string, len(names))
+
+		for i, name := range names {
+			values[i] = l[name]
+		}
+
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(c.itemsCountDesc, prometheus.GaugeValue, float64(agg.count), values...),
+			prometheus.MustNewConstMetric(c.itemsBytesDesc, prometheus.GaugeValue, float64(agg.bytes), values...),
+		)
+	}
+
+	metrics = append(metrics, prometheus.MustNewConstMetric(c.sessionsActiveDesc, prometheus.GaugeValue, float64(result.sessionCount)))
+
+	transcodeCounts := make(map[[2]string]int)
+
+	for _, session := range result.transcodeSessions {
+		key := [2]string{session.Reason, strconv.FormatBool(session.Throttled)}
+		transcodeCounts[key]++
+	}
+
+	for key, count := range transcodeCounts {
+		metrics = append(metrics, prometheus.MustNewConstMetric(c.transcodeSessionsDesc, prometheus.GaugeValue, float64(count), key[0], key[1]))
+	}
+
+	for playlist, stats := range result.playlists {
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(c.playlistItemsDesc, prometheus.GaugeValue, float64(stats.count), playlist, stats.typ),
+			prometheus.MustNewConstMetric(c.playlistBytesDesc, prometheus.GaugeValue, float64(stats.bytes), playlist, stats.typ),
+		)
+	}
+
+	return metrics
+}
+
+// analyzeItems flattens items into MediaItems, recursing into shows/seasons
+// to fetch their children. Recursive fetches run in a nested pool bounded by
+// the same semaphore used for the top-level library scan, so the total
+// number of in-flight Plex API calls never exceeds c.concurrency.
+func (c *Collector) analyzeItems(ctx context.Context, sem semaphore, items []Item, libraryName, sectionKey string) ([]*MediaItem, error) {
+	group, groupCtx := errgroup.WithContext(ctx)
+
+	var mu sync.Mutex
+	newMediaItems := make([]*MediaItem, 0)
+
+	for _, item := range items {
+		item := item
+
+		switch item.Type {
+		case "artist", "album":
+			continue
+		case "show", "season":
+			group.Go(func() error {
+				var children []Item
+
+				sem.acquire()
+				err := c.call(groupCtx, func(ctx context.Context) error {
+					var err error
+					children, err = c.client.GetMetadataChildren(ctx, item.RatingKey)
+					return err
+				})
+				sem.release()
+
+				if err != nil {
+					return err
+				}
+
+				mediaItems, err := c.analyzeItems(groupCtx, sem, children, libraryName, sectionKey)
+
+				if err != nil {
+					return err
+				}
+
+				mu.Lock()
+				newMediaItems = append(newMediaItems, mediaItems...)
+				mu.Unlock()
+
+				return nil
+			})
+		case "movie", "episode":
+			mediaItems := c.analyzeItem(item, libraryName, sectionKey)
+
+			mu.Lock()
+			newMediaItems = append(newMediaItems, mediaItems...)
+			mu.Unlock()
+		default:
+			return nil, fmt.Errorf("unknown item type: %s", item.Type)
+		}
+	}
+
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+
+	return newMediaItems, nil
+}
+
+func (c *Collector) analyzeItem(item Item, libraryName, sectionKey string) []*MediaItem {
+	mediaItems := make([]*MediaItem, 0)
+
+	for _, media := range item.Media {
+		if media.DeletedAt != 0 {
+			continue
+		}
+
+		if media.AudioChannels == 0 && media.VideoResolution == "" {
+			continue
+		}
+
+		size := 0
+
+		for _, part := range media.Parts {
+			size += part.Size
+		}
+
+		bitrate := 0
+
+		if item.Duration > 0 {
+			bitrate = int(float64(size*8) / (float64(item.Duration) / 1000))
+		}
+
+		mediaItem := &MediaItem{
+			id:                   media.ID,
+			audioChannels:        media.AudioChannels,
+			audioCodec:           media.AudioCodec,
+			bitrate:              bitrate,
+			container:            media.Container,
+			grandParentRatingKey: item.GrandparentRatingKey,
+			hdr:                  media.DynamicRange != "" && !strings.EqualFold(media.DynamicRange, "SDR"),
+			libraryName:          libraryName,
+			mediaType:            item.Type,
+			parentRatingKey:      item.ParentRatingKey,
+			sectionKey:           sectionKey,
+			size:                 size,
+			videoCodec:           media.VideoCodec,
+			videoResolution:      media.VideoResolution,
+		}
+
+		mediaItems = append(mediaItems, mediaItem)
+	}
+
+	return mediaItems
+}
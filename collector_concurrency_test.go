@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestCollectorScrapeRespectsConcurrency checks that the semaphore shared by
+// scrape's library pool actually bounds the number of in-flight Plex API
+// calls to --concurrency, rather than just limiting goroutine count.
+func TestCollectorScrapeRespectsConcurrency(t *testing.T) {
+	const libraryCount = 8
+	const concurrency = 2
+
+	client := &fakePlexAPI{
+		callDelay: 10 * time.Millisecond,
+		items:     make(map[string][]Item, libraryCount),
+	}
+
+	for i := 0; i < libraryCount; i++ {
+		key := fmt.Sprintf("%d", i)
+		client.libraries = append(client.libraries, Library{Key: key, Title: key})
+		client.items[key] = nil
+	}
+
+	c := NewCollector(client, CollectorConfig{Concurrency: concurrency})
+
+	if _, err := c.scrape(context.Background()); err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+
+	client.mu.Lock()
+	maxInFlight := client.maxInFlight
+	client.mu.Unlock()
+
+	if maxInFlight > concurrency {
+		t.Fatalf("got %d calls in flight at once, want at most %d", maxInFlight, concurrency)
+	}
+
+	if maxInFlight < concurrency {
+		t.Fatalf("got %d calls in flight at once, want the pool to reach %d", maxInFlight, concurrency)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// fakePlexAPI is a minimal, in-memory plexAPI used to exercise Collector
+// without a real Plex Media Server. mu/inFlight/maxInFlight let tests assert
+// on how many of its methods Collector had running concurrently.
+type fakePlexAPI struct {
+	libraries []Library
+	items     map[string][]Item
+	children  map[string][]Item
+
+	callDelay time.Duration
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (f *fakePlexAPI) track() func() {
+	f.mu.Lock()
+	f.inFlight++
+
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+
+	f.mu.Unlock()
+
+	if f.callDelay > 0 {
+		time.Sleep(f.callDelay)
+	}
+
+	return func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}
+}
+
+func (f *fakePlexAPI) Ping(ctx context.Context) error { return nil }
+
+func (f *fakePlexAPI) GetLibraries(ctx context.Context) ([]Library, error) {
+	return f.libraries, nil
+}
+
+func (f *fakePlexAPI) GetLibraryItems(ctx context.Context, sectionKey string) ([]Item, error) {
+	defer f.track()()
+
+	return f.items[sectionKey], nil
+}
+
+func (f *fakePlexAPI) GetMetadataChildren(ctx context.Context, ratingKey string) ([]Item, error) {
+	defer f.track()()
+
+	return f.children[ratingKey], nil
+}
+
+func (f *fakePlexAPI) GetPlaylists(ctx context.Context) ([]Playlist, error) {
+	return nil, nil
+}
+
+func (f *fakePlexAPI) GetPlaylistItems(ctx context.Context, playlistKey string) ([]Item, error) {
+	return nil, nil
+}
+
+func (f *fakePlexAPI) GetSessions(ctx context.Context) ([]Session, error) {
+	return nil, nil
+}
+
+func (f *fakePlexAPI) GetTranscodeSessions(ctx context.Context) ([]TranscodeSession, error) {
+	return nil, nil
+}
+
+func TestCollectorScrape(t *testing.T) {
+	client := &fakePlexAPI{
+		libraries: []Library{
+			{Key: "1", Title: "Movies"},
+		},
+		items: map[string][]Item{
+			"1": {
+				{
+					RatingKey: "100",
+					Type:      "movie",
+					Duration:  1000,
+					Media: []Media{
+						{
+							AudioChannels:   2,
+							AudioCodec:      "aac",
+							VideoCodec:      "h264",
+							VideoResolution: "1080",
+							Parts:           []Part{{Size: 1_000_000}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	c := NewCollector(client, CollectorConfig{Concurrency: 2})
+
+	result, err := c.scrape(context.Background())
+
+	if err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+
+	if len(result.items) != 1 {
+		t.Fatalf("got %d media items, want 1", len(result.items))
+	}
+
+	if got := result.items[0].size; got != 1_000_000 {
+		t.Fatalf("got size %d, want 1000000", got)
+	}
+
+	if got := result.items[0].videoCodec; got != "h264" {
+		t.Fatalf("got video codec %q, want h264", got)
+	}
+}
+
+func TestCollectorScrapeSkipsUnchangedSection(t *testing.T) {
+	client := &fakePlexAPI{
+		libraries: []Library{
+			{Key: "1", Title: "Movies"},
+		},
+	}
+
+	c := NewCollector(client, CollectorConfig{Concurrency: 1})
+	c.lastSuccess = client.libraries[0].UpdatedAt.Add(time.Hour)
+	c.mediaItems = []*MediaItem{{id: 1, sectionKey: "1", size: 42}}
+
+	result, err := c.scrape(context.Background())
+
+	if err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+
+	if len(result.items) != 1 || result.items[0].size != 42 {
+		t.Fatalf("got %+v, want the previous media item carried forward", result.items)
+	}
+}
+
+func TestCollectorBuildMetricsAggregatesByLabelSet(t *testing.T) {
+	c := NewCollector(&fakePlexAPI{}, CollectorConfig{Concurrency: 1})
+
+	result := scrapeResult{
+		items: []*MediaItem{
+			{mediaType: "movie", size: 100},
+			{mediaType: "movie", size: 200},
+			{mediaType: "episode", size: 50},
+		},
+	}
+
+	var gotCount, gotBytes float64
+
+	for _, m := range c.buildMetrics(result) {
+		var pb dto.Metric
+
+		if err := m.Write(&pb); err != nil {
+			t.Fatalf("write metric: %v", err)
+		}
+
+		isMovie := false
+
+		for _, l := range pb.GetLabel() {
+			if l.GetName() == "media_type" && l.GetValue() == "movie" {
+				isMovie = true
+			}
+		}
+
+		if !isMovie {
+			continue
+		}
+
+		switch m.Desc() {
+		case c.itemsCountDesc:
+			gotCount += pb.GetGauge().GetValue()
+		case c.itemsBytesDesc:
+			gotBytes += pb.GetGauge().GetValue()
+		}
+	}
+
+	if gotCount != 2 {
+		t.Fatalf("got count %v, want 2", gotCount)
+	}
+
+	if gotBytes != 300 {
+		t.Fatalf("got bytes %v, want 300", gotBytes)
+	}
+}
@@ -1,297 +1,185 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"github.com/jyggen/go-plex-client"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/urfave/cli/v2"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/urfave/cli/v2"
 )
 
-func Contains(a []string, x string) bool {
-	for _, n := range a {
-		if x == n {
-			return true
+// splitCSV splits a comma-separated flag value into its trimmed, non-empty
+// elements.
+func splitCSV(raw string) []string {
+	var values []string
+
+	for _, value := range strings.Split(raw, ",") {
+		value = strings.TrimSpace(value)
+
+		if value != "" {
+			values = append(values, value)
 		}
 	}
-	return false
-}
 
-type MediaItem struct {
-	id                   int
-	audioChannels        int
-	audioCodec           string
-	grandParentRatingKey string
-	mediaType            string
-	parentRatingKey      string
-	sectionKey           string
-	size                 int
-	videoCodec           string
-	videoResolution      string
+	return values
 }
 
-func (m *MediaItem) Diff(x *MediaItem) bool {
-	if m.audioChannels != x.audioChannels {
-		return true
-	}
+// configureLogger installs a slog logger built from --log-level and
+// --log-format as the process-wide default.
+func configureLogger(c *cli.Context) error {
+	var level slog.Level
 
-	if m.audioCodec != x.audioCodec {
-		return true
+	if err := level.UnmarshalText([]byte(c.String("log-level"))); err != nil {
+		return fmt.Errorf("invalid --log-level: %w", err)
 	}
 
-	if m.videoCodec != x.videoCodec {
-		return true
-	}
+	opts := &slog.HandlerOptions{Level: level}
 
-	if m.videoResolution != x.videoResolution {
-		return true
+	var handler slog.Handler
+
+	switch format := c.String("log-format"); format {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid --log-format: %q", format)
 	}
 
-	return false
-}
+	slog.SetDefault(slog.New(handler))
 
-func (m *MediaItem) Gauge(gauge *prometheus.GaugeVec) prometheus.Gauge {
-	return gauge.With(prometheus.Labels{
-		"audio_channels":   strconv.Itoa(m.audioChannels),
-		"audio_codec":      m.audioCodec,
-		"media_type":       m.mediaType,
-		"video_codec":      m.videoCodec,
-		"video_resolution": m.videoResolution,
-	})
-}
-
-type Collector struct {
-	client             *plex.Plex
-	lastRun            time.Time
-	mediaItems         []*MediaItem
-	skippedSectionKeys []string
+	return nil
 }
 
-func (c *Collector) Collect() error {
-	c.skippedSectionKeys = make([]string, 0)
-
-	// Generate a new last run straight away to avoid edge cases.
-	newLastRun := time.Now()
-	newMediaItems := make([]*MediaItem, 0)
-
-	libraries, err := c.client.GetLibraries()
-
-	if err != nil {
+func bootstrap(c *cli.Context) error {
+	if err := configureLogger(c); err != nil {
 		return err
 	}
 
-	for _, library := range libraries.MediaContainer.Directory {
-		updatedAt := time.Unix(int64(library.UpdatedAt), 0)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		if updatedAt.Before(c.lastRun) {
-			c.skippedSectionKeys = append(c.skippedSectionKeys, library.Key)
-			continue
-		}
+	plexClient := newPlexgoAPI(c.String("url"), c.String("token"))
 
-		content, err := c.client.GetLibraryContent(library.Key, "")
+	if err := plexClient.Ping(ctx); err != nil {
+		return err
+	}
 
-		if err != nil {
-			return err
-		}
+	var store Store
 
-		mediaItems, err := c.analyzeItems(content.MediaContainer.MediaContainer)
+	if path := c.String("state-file"); path != "" {
+		sqliteStore, err := newSQLiteStore(path)
 
 		if err != nil {
 			return err
 		}
 
-		newMediaItems = append(newMediaItems, mediaItems...)
-	}
-
-	oldMediaItemsMap := make(map[int]*MediaItem, len(c.mediaItems))
+		defer sqliteStore.Close()
 
-	for _, mediaItem := range c.mediaItems {
-		oldMediaItemsMap[mediaItem.id] = mediaItem
+		store = sqliteStore
 	}
 
-	newMediaItemsMap := make(map[int]*MediaItem, 0)
-	added, updated, removed := 0, 0, 0
-
-	for _, mediaItem := range newMediaItems {
-		newMediaItemsMap[mediaItem.id] = mediaItem
-
-		if _, ok := oldMediaItemsMap[mediaItem.id]; !ok {
-			mediaItem.Gauge(mediaItemsCount).Inc()
-			mediaItem.Gauge(mediaItemsBytes).Add(float64(mediaItem.size))
-			added++
-			continue
-		}
-
-		oldItem := oldMediaItemsMap[mediaItem.id]
+	collector := NewCollector(plexClient, CollectorConfig{
+		CacheTTL:         c.Duration("cache-ttl"),
+		Labels:           splitCSV(c.String("labels")),
+		IncludeSections:  splitCSV(c.String("include-section")),
+		ExcludeSections:  splitCSV(c.String("exclude-section")),
+		IncludePlaylists: splitCSV(c.String("include-playlist")),
+		Concurrency:      c.Int("concurrency"),
+		RequestTimeout:   c.Duration("request-timeout"),
+		Store:            store,
+	})
 
-		if mediaItem.Diff(oldItem) {
-			oldItem.Gauge(mediaItemsCount).Dec()
-			mediaItem.Gauge(mediaItemsCount).Inc()
-			oldItem.Gauge(mediaItemsBytes).Sub(float64(oldItem.size))
-			mediaItem.Gauge(mediaItemsBytes).Add(float64(mediaItem.size))
-			updated++
-		}
+	registry := prometheus.NewRegistry()
 
-		delete(oldMediaItemsMap, mediaItem.id)
+	if err := registry.Register(collector); err != nil {
+		return err
 	}
 
-	for _, mediaItem := range oldMediaItemsMap {
-		if Contains(c.skippedSectionKeys, mediaItem.sectionKey) {
-			newMediaItems = append(newMediaItems, mediaItem)
-			continue
-		}
-
-		mediaItem.Gauge(mediaItemsCount).Dec()
-		mediaItem.Gauge(mediaItemsBytes).Sub(float64(mediaItem.size))
+	pollInterval := c.Duration("poll-interval")
 
-		removed++
+	if !c.IsSet("poll-interval") && c.Bool("webhook") {
+		// Webhooks make most full reconciliations redundant, so fall back
+		// to polling far less aggressively unless the operator asked for a
+		// specific interval.
+		pollInterval = time.Hour
 	}
 
-	c.mediaItems = newMediaItems
-	c.lastRun = newLastRun
-
-	log.Printf("Collection of %d media items finished. Added %d, updated %d, and removed %d.\n", len(c.mediaItems), added, updated, removed)
-
-	return nil
-}
-
-func (c *Collector) analyzeItems(container plex.MediaContainer) ([]*MediaItem, error) {
-	newMediaItems := make([]*MediaItem, 0)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
 
-	for _, item := range container.Metadata {
-		if item.Type == "artist" || item.Type == "album" {
-			continue
-		} else if item.Type == "show" || item.Type == "season" {
-			content, err := c.client.GetMetadataChildren(item.RatingKey)
-
-			if err != nil {
-				return newMediaItems, err
-			}
-
-			mediaItems, err := c.analyzeItems(content.MediaContainer)
-
-			if err != nil {
-				return newMediaItems, err
-			}
-
-			newMediaItems = append(newMediaItems, mediaItems...)
-		} else if item.Type == "movie" || item.Type == "episode" {
-			mediaItems, err := c.analyzeItem(item, container)
-
-			if err != nil {
-				return newMediaItems, err
-			}
-
-			newMediaItems = append(newMediaItems, mediaItems...)
-		} else {
-			return newMediaItems, errors.New(fmt.Sprintf("Unknown item type: %s", item.Type))
-		}
+	if c.Bool("webhook") {
+		mux.Handle("/plex/webhook", collector)
 	}
 
-	return newMediaItems, nil
-}
-
-func (c *Collector) analyzeItem(item plex.Metadata, container plex.MediaContainer) ([]*MediaItem, error) {
-	mediaItems := make([]*MediaItem, 0)
-
-	for _, media := range item.Media {
-		if media.DeletedAt != 0 {
-			continue
-		}
-
-		if media.AudioChannels == 0 && media.VideoResolution == "" {
-			continue
-		}
-
-		size := 0
-
-		for _, part := range media.Part {
-			size += part.Size
-		}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
 
-		mediaItem := &MediaItem{
-			id:                   media.ID,
-			audioChannels:        media.AudioChannels,
-			audioCodec:           media.AudioCodec,
-			grandParentRatingKey: item.GrandparentRatingKey,
-			mediaType:            item.Type,
-			parentRatingKey:      item.ParentRatingKey,
-			sectionKey:           strconv.Itoa(container.LibrarySectionID),
-			size:                 size,
-			videoCodec:           media.VideoCodec,
-			videoResolution:      media.VideoResolution,
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := collector.Ready(r.Context(), 2*pollInterval); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
 		}
 
-		mediaItems = append(mediaItems, mediaItem)
-	}
-
-	return mediaItems, nil
-}
-
-var mediaCollection = make(map[int]*MediaItem, 0)
-var labels = []string{"audio_channels", "audio_codec", "media_type", "video_codec", "video_resolution"}
-var mediaItemsCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
-	Name: "plex_media_items_count_total",
-	Help: "The total count of media items.",
-}, labels)
-var mediaItemsBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
-	Name: "plex_media_items_bytes_total",
-	Help: "The total bytes size of media items.",
-}, labels)
-
-func bootstrap(c *cli.Context) error {
-	plexClient, err := plex.New(c.String("url"), c.String("token"))
-
-	if err != nil {
-		return err
-	}
-
-	_, err = plexClient.Test()
-
-	if err != nil {
-		return err
-	}
-
-	collector := &Collector{
-		client: plexClient,
-	}
-
-	err = collector.Collect()
+		w.WriteHeader(http.StatusOK)
+	})
 
-	if err != nil {
-		return err
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", c.Int("port")),
+		Handler: mux,
 	}
 
-	ticker := time.NewTicker(10 * time.Minute)
-	quit := make(chan struct{})
+	ticker := time.NewTicker(pollInterval)
+	tickerDone := make(chan struct{})
 
 	go func() {
+		defer close(tickerDone)
+		defer ticker.Stop()
+
 		for {
 			select {
 			case <-ticker.C:
-				err = collector.Collect()
-
-				if err != nil {
-					log.Println(err)
-				}
-			case <-quit:
-				ticker.Stop()
+				collector.Refresh()
+			case <-ctx.Done():
 				return
 			}
 		}
 	}()
 
-	http.Handle("/metrics", promhttp.Handler())
-	http.ListenAndServe(fmt.Sprintf(":%d", c.Int("port")), nil)
+	serveErr := make(chan error, 1)
 
-	return nil
+	go func() {
+		slog.Info("listening", "addr", server.Addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+
+		return err
+	case <-ctx.Done():
+		slog.Info("shutting down")
+	}
+
+	<-tickerDone
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return server.Shutdown(shutdownCtx)
 }
 
 func main() {
@@ -320,13 +208,78 @@ func main() {
 				EnvVars:  []string{"PLEX_URL"},
 				Required: true,
 			},
+			&cli.DurationFlag{
+				Name:    "cache-ttl",
+				Value:   5 * time.Minute,
+				Usage:   "How long a scrape snapshot may be served from cache before triggering a refresh.",
+				EnvVars: []string{"CACHE_TTL"},
+			},
+			&cli.StringFlag{
+				Name:    "labels",
+				Usage:   "Comma-separated allow-list of optional labels to expose on media item gauges (bitrate_bucket, container, hdr, library, section_id). audio_channels, audio_codec, video_codec and video_resolution are always included.",
+				EnvVars: []string{"LABELS"},
+			},
+			&cli.StringFlag{
+				Name:    "include-section",
+				Usage:   "Comma-separated list of library section names to collect. All sections are collected if unset.",
+				EnvVars: []string{"INCLUDE_SECTION"},
+			},
+			&cli.StringFlag{
+				Name:    "exclude-section",
+				Usage:   "Comma-separated list of library section names to skip. Takes precedence over --include-section.",
+				EnvVars: []string{"EXCLUDE_SECTION"},
+			},
+			&cli.StringFlag{
+				Name:    "include-playlist",
+				Usage:   "Comma-separated list of playlist names to collect. All playlists are collected if unset.",
+				EnvVars: []string{"INCLUDE_PLAYLIST"},
+			},
+			&cli.IntFlag{
+				Name:    "concurrency",
+				Value:   4,
+				Usage:   "Maximum number of Plex API requests a single scrape may have in flight at once.",
+				EnvVars: []string{"CONCURRENCY"},
+			},
+			&cli.DurationFlag{
+				Name:    "request-timeout",
+				Value:   30 * time.Second,
+				Usage:   "Per-request timeout for calls to the Plex API.",
+				EnvVars: []string{"REQUEST_TIMEOUT"},
+			},
+			&cli.BoolFlag{
+				Name:    "webhook",
+				Usage:   "Serve /plex/webhook to receive Plex webhook events for near-real-time updates.",
+				EnvVars: []string{"WEBHOOK"},
+			},
+			&cli.DurationFlag{
+				Name:    "poll-interval",
+				Value:   10 * time.Minute,
+				Usage:   "How often to perform a full reconciliation with Plex, as a fallback to webhook events. Defaults to 1h when --webhook is set.",
+				EnvVars: []string{"POLL_INTERVAL"},
+			},
+			&cli.StringFlag{
+				Name:    "state-file",
+				Usage:   "Path to a SQLite database used to persist collector state across restarts. State is kept in memory only if unset.",
+				EnvVars: []string{"STATE_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "log-level",
+				Value:   "info",
+				Usage:   "Log level: debug, info, warn, or error.",
+				EnvVars: []string{"LOG_LEVEL"},
+			},
+			&cli.StringFlag{
+				Name:    "log-format",
+				Value:   "text",
+				Usage:   "Log format: text or json.",
+				EnvVars: []string{"LOG_FORMAT"},
+			},
 		},
 		Action: bootstrap,
 	}
 
-	err := app.Run(os.Args)
-
-	if err != nil {
-		log.Fatal(err)
+	if err := app.Run(os.Args); err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
 	}
 }
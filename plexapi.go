@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/LukeHagar/plexgo"
+	"github.com/LukeHagar/plexgo/models/operations"
+)
+
+// Library is a single Plex library section. UpdatedAt lets Collector skip
+// re-scraping sections that haven't changed since the last scrape.
+type Library struct {
+	Key       string
+	Title     string
+	UpdatedAt time.Time
+}
+
+// Item is a single piece of Plex metadata: a movie, episode, show, season,
+// artist or album.
+type Item struct {
+	RatingKey            string
+	Type                 string
+	GrandparentRatingKey string
+	ParentRatingKey      string
+	Duration             int64
+	Media                []Media
+}
+
+// Media is one version of an Item (e.g. a specific encode of a movie).
+type Media struct {
+	ID              int
+	AudioChannels   int
+	AudioCodec      string
+	VideoCodec      string
+	VideoResolution string
+	DynamicRange    string
+	Container       string
+	DeletedAt       int64
+	Parts           []Part
+}
+
+// Part is a single file backing a Media.
+type Part struct {
+	Size int
+}
+
+// Playlist is a Plex playlist.
+type Playlist struct {
+	Key   string
+	Title string
+	Type  string
+}
+
+// Session is an active playback session.
+type Session struct {
+	User      string
+	Player    string
+	MediaType string
+}
+
+// TranscodeSession is an active transcode backing a Session.
+type TranscodeSession struct {
+	Key       string
+	Reason    string
+	Throttled bool
+}
+
+// plexAPI is the subset of the Plex Media Server API that Collector needs.
+// It exists so Collector can be exercised with a fake in unit tests instead
+// of a real server.
+type plexAPI interface {
+	Ping(ctx context.Context) error
+	GetLibraries(ctx context.Context) ([]Library, error)
+	GetLibraryItems(ctx context.Context, sectionKey string) ([]Item, error)
+	GetMetadataChildren(ctx context.Context, ratingKey string) ([]Item, error)
+	GetPlaylists(ctx context.Context) ([]Playlist, error)
+	GetPlaylistItems(ctx context.Context, playlistKey string) ([]Item, error)
+	GetSessions(ctx context.Context) ([]Session, error)
+	GetTranscodeSessions(ctx context.Context) ([]TranscodeSession, error)
+}
+
+// plexgoAPI adapts github.com/LukeHagar/plexgo's typed client to plexAPI.
+type plexgoAPI struct {
+	client *plexgo.PlexAPI
+}
+
+func newPlexgoAPI(baseURL, token string) *plexgoAPI {
+	return &plexgoAPI{
+		client: plexgo.New(
+			plexgo.WithServerURL(baseURL),
+			plexgo.WithSecurity(token),
+		),
+	}
+}
+
+// Ping verifies the configured token and URL can reach the server, mirroring
+// the connectivity check the old go-plex-client Test method performed.
+func (p *plexgoAPI) Ping(ctx context.Context) error {
+	_, err := p.client.Server.GetServerCapabilities(ctx)
+
+	return err
+}
+
+func (p *plexgoAPI) GetLibraries(ctx context.Context) ([]Library, error) {
+	res, err := p.client.Library.GetAllLibraries(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	libraries := make([]Library, 0, len(res.Object.MediaContainer.Directory))
+
+	for _, dir := range res.Object.MediaContainer.Directory {
+		libraries = append(libraries, Library{
+			Key:       dir.Key,
+			Title:     dir.Title,
+			UpdatedAt: time.Unix(dir.UpdatedAt, 0),
+		})
+	}
+
+	return libraries, nil
+}
+
+func (p *plexgoAPI) GetLibraryItems(ctx context.Context, sectionKey string) ([]Item, error) {
+	res, err := p.client.Library.GetLibraryItems(ctx, operations.GetLibraryItemsRequest{
+		SectionKey: sectionKey,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return metadataToItems(res.Object.MediaContainer.Metadata), nil
+}
+
+func (p *plexgoAPI) GetMetadataChildren(ctx context.Context, ratingKey string) ([]Item, error) {
+	res, err := p.client.Library.GetMetadataChildren(ctx, operations.GetMetadataChildrenRequest{
+		RatingKey: ratingKey,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return metadataToItems(res.Object.MediaContainer.Metadata), nil
+}
+
+func (p *plexgoAPI) GetPlaylists(ctx context.Context) ([]Playlist, error) {
+	res, err := p.client.Playlists.GetPlaylists(ctx, operations.GetPlaylistsRequest{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	playlists := make([]Playlist, 0, len(res.Object.MediaContainer.Metadata))
+
+	for _, pl := range res.Object.MediaContainer.Metadata {
+		playlists = append(playlists, Playlist{
+			Key:   pl.RatingKey,
+			Title: pl.Title,
+			Type:  pl.PlaylistType,
+		})
+	}
+
+	return playlists, nil
+}
+
+func (p *plexgoAPI) GetPlaylistItems(ctx context.Context, playlistKey string) ([]Item, error) {
+	res, err := p.client.Playlists.GetPlaylistContents(ctx, operations.GetPlaylistContentsRequest{
+		PlaylistID: playlistKey,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return metadataToItems(res.Object.MediaContainer.Metadata), nil
+}
+
+func (p *plexgoAPI) GetSessions(ctx context.Context) ([]Session, error) {
+	res, err := p.client.Sessions.GetSessions(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(res.Object.MediaContainer.Metadata))
+
+	for _, s := range res.Object.MediaContainer.Metadata {
+		sessions = append(sessions, Session{
+			User:      s.User.Title,
+			Player:    s.Player.Title,
+			MediaType: s.Type,
+		})
+	}
+
+	return sessions, nil
+}
+
+func (p *plexgoAPI) GetTranscodeSessions(ctx context.Context) ([]TranscodeSession, error) {
+	res, err := p.client.Sessions.GetTranscodeSessions(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]TranscodeSession, 0, len(res.Object.MediaContainer.TranscodeSession))
+
+	for _, s := range res.Object.MediaContainer.TranscodeSession {
+		sessions = append(sessions, TranscodeSession{
+			Key:       s.Key,
+			Reason:    s.Context,
+			Throttled: s.Throttled,
+		})
+	}
+
+	return sessions, nil
+}
+
+func metadataToItems(metadata []operations.Metadata) []Item {
+	items := make([]Item, 0, len(metadata))
+
+	for _, m := range metadata {
+		media := make([]Media, 0, len(m.Media))
+
+		for _, md := range m.Media {
+			parts := make([]Part, 0, len(md.Part))
+
+			for _, part := range md.Part {
+				parts = append(parts, Part{Size: int(part.Size)})
+			}
+
+			media = append(media, Media{
+				ID:              int(md.ID),
+				AudioChannels:   int(md.AudioChannels),
+				AudioCodec:      md.AudioCodec,
+				VideoCodec:      md.VideoCodec,
+				VideoResolution: md.VideoResolution,
+				DynamicRange:    md.VideoDynamicRange,
+				Container:       md.Container,
+				DeletedAt:       md.DeletedAt,
+				Parts:           parts,
+			})
+		}
+
+		items = append(items, Item{
+			RatingKey:            m.RatingKey,
+			Type:                 m.Type,
+			GrandparentRatingKey: m.GrandparentRatingKey,
+			ParentRatingKey:      m.ParentRatingKey,
+			Duration:             m.Duration,
+			Media:                media,
+		})
+	}
+
+	return items
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// StoredState is the subset of Collector state that survives a restart: the
+// last known media items (so gauges don't reset to zero and re-count
+// everything as "added") and the timestamp of the last successful scrape.
+type StoredState struct {
+	MediaItems []*MediaItem
+	LastRun    time.Time
+}
+
+// Store persists Collector state between process restarts. Save is called
+// transactionally at the end of every successful scrape, whether Collect or
+// Refresh triggered it; a Collect served from the cached snapshot does not
+// call Save. Load is called once, in bootstrap, before the collector serves
+// its first request.
+type Store interface {
+	Load() (StoredState, error)
+	Save(state StoredState) error
+	Close() error
+}
+
+// memoryStore keeps state only for the lifetime of the process. It backs
+// --state-file="" (the default) and is useful in tests that don't care
+// about persistence.
+type memoryStore struct {
+	mu    sync.Mutex
+	state StoredState
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{}
+}
+
+func (m *memoryStore) Load() (StoredState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.state, nil
+}
+
+func (m *memoryStore) Save(state StoredState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.state = state
+
+	return nil
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
+
+// sqliteStore persists state to a SQLite database file via modernc.org/sqlite,
+// a pure-Go driver, so persistence doesn't require a cgo toolchain.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func newSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS state (
+		id INTEGER PRIMARY KEY CHECK (id = 0),
+		last_run INTEGER NOT NULL,
+		media_items TEXT NOT NULL
+	)`)
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteStore{db: db}, nil
+}
+
+func (s *sqliteStore) Load() (StoredState, error) {
+	var lastRunUnix int64
+	var mediaItemsJSON string
+
+	err := s.db.QueryRow(`SELECT last_run, media_items FROM state WHERE id = 0`).Scan(&lastRunUnix, &mediaItemsJSON)
+
+	if err == sql.ErrNoRows {
+		return StoredState{}, nil
+	}
+
+	if err != nil {
+		return StoredState{}, err
+	}
+
+	var mediaItems []*MediaItem
+
+	if err := json.Unmarshal([]byte(mediaItemsJSON), &mediaItems); err != nil {
+		return StoredState{}, err
+	}
+
+	return StoredState{MediaItems: mediaItems, LastRun: time.Unix(lastRunUnix, 0)}, nil
+}
+
+func (s *sqliteStore) Save(state StoredState) error {
+	mediaItemsJSON, err := json.Marshal(state.MediaItems)
+
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO state (id, last_run, media_items) VALUES (0, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET last_run = excluded.last_run, media_items = excluded.media_items`,
+		state.LastRun.Unix(), string(mediaItemsJSON))
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
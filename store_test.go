@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreRoundTrip(t *testing.T) {
+	store := newMemoryStore()
+
+	want := StoredState{
+		MediaItems: []*MediaItem{{id: 1, mediaType: "movie", size: 42}},
+		LastRun:    time.Unix(1700000000, 0),
+	}
+
+	if err := store.Save(want); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	got, err := store.Load()
+
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if !got.LastRun.Equal(want.LastRun) {
+		t.Fatalf("got LastRun %v, want %v", got.LastRun, want.LastRun)
+	}
+
+	if len(got.MediaItems) != 1 || *got.MediaItems[0] != *want.MediaItems[0] {
+		t.Fatalf("got %+v, want %+v", got.MediaItems, want.MediaItems)
+	}
+}
+
+func TestSQLiteStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+
+	store, err := newSQLiteStore(path)
+
+	if err != nil {
+		t.Fatalf("newSQLiteStore: %v", err)
+	}
+
+	defer store.Close()
+
+	want := StoredState{
+		MediaItems: []*MediaItem{
+			{
+				id:                   1,
+				audioChannels:        2,
+				audioCodec:           "aac",
+				bitrate:              5_000_000,
+				container:            "mkv",
+				grandParentRatingKey: "10",
+				hdr:                  true,
+				libraryName:          "Movies",
+				mediaType:            "movie",
+				parentRatingKey:      "11",
+				sectionKey:           "1",
+				size:                 123456,
+				videoCodec:           "hevc",
+				videoResolution:      "2160",
+			},
+		},
+		LastRun: time.Unix(1700000000, 0),
+	}
+
+	if err := store.Save(want); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	got, err := store.Load()
+
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if !got.LastRun.Equal(want.LastRun) {
+		t.Fatalf("got LastRun %v, want %v", got.LastRun, want.LastRun)
+	}
+
+	if len(got.MediaItems) != 1 {
+		t.Fatalf("got %d media items, want 1", len(got.MediaItems))
+	}
+
+	// MediaItem's fields are all unexported, so the only way to verify
+	// Save/Load round-tripped it correctly is through its hand-written
+	// MarshalJSON/UnmarshalJSON, exercised here via the real store.
+	if *got.MediaItems[0] != *want.MediaItems[0] {
+		t.Fatalf("got %+v, want %+v", *got.MediaItems[0], *want.MediaItems[0])
+	}
+}
+
+func TestSQLiteStoreLoadEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+
+	store, err := newSQLiteStore(path)
+
+	if err != nil {
+		t.Fatalf("newSQLiteStore: %v", err)
+	}
+
+	defer store.Close()
+
+	got, err := store.Load()
+
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if len(got.MediaItems) != 0 || !got.LastRun.IsZero() {
+		t.Fatalf("got %+v, want zero-value state", got)
+	}
+}
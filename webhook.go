@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// maxWebhookBody caps the multipart body Plex webhooks are allowed to send;
+// payloads are a small JSON blob plus an optional thumbnail image.
+const maxWebhookBody = 10 << 20 // 10 MiB
+
+// webhookPayload is the subset of Plex's webhook JSON payload (sent as the
+// "payload" field of a multipart/form-data POST) that the collector cares
+// about. See https://support.plex.tv/articles/115002267687-webhooks/.
+type webhookPayload struct {
+	Event   string `json:"event"`
+	Account struct {
+		Title string `json:"title"`
+	} `json:"Account"`
+	Player struct {
+		Title string `json:"title"`
+	} `json:"Player"`
+	Metadata struct {
+		Type                 string `json:"type"`
+		LibrarySectionTitle  string `json:"librarySectionTitle"`
+		GrandparentRatingKey string `json:"grandparentRatingKey"`
+		RatingKey            string `json:"ratingKey"`
+	} `json:"Metadata"`
+}
+
+// ServeHTTP implements the /plex/webhook endpoint. It accepts Plex's
+// multipart webhook payloads and updates the collector's in-memory state
+// incrementally, so the playback and event gauges reflect changes within
+// seconds rather than waiting for the next poll.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebhookBody)
+
+	if err := r.ParseMultipartForm(maxWebhookBody); err != nil {
+		http.Error(w, "invalid webhook payload", http.StatusBadRequest)
+		return
+	}
+
+	raw := r.FormValue("payload")
+
+	var payload webhookPayload
+
+	if err := json.Unmarshal([]byte(raw), &payload); err != nil {
+		http.Error(w, "invalid webhook payload", http.StatusBadRequest)
+		return
+	}
+
+	c.handleWebhookEvent(payload)
+
+	io.Copy(io.Discard, r.Body)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleWebhookEvent updates the event/session gauges for a single webhook
+// delivery. It touches only the gauges themselves (already safe for
+// concurrent use) and the lock-free forceRefresh flag, never c.mu, so a
+// webhook POST never waits behind an in-progress scrape.
+func (c *Collector) handleWebhookEvent(payload webhookPayload) {
+	c.mediaEventsTotal.WithLabelValues(payload.Event, payload.Metadata.LibrarySectionTitle).Inc()
+
+	switch payload.Event {
+	case "media.play":
+		c.playbackSessionsActive.WithLabelValues(payload.Account.Title, payload.Player.Title, payload.Metadata.Type).Set(1)
+	case "media.stop", "media.scrobble":
+		c.playbackSessionsActive.DeleteLabelValues(payload.Account.Title, payload.Player.Title, payload.Metadata.Type)
+	case "library.new", "library.on.deck":
+		// We don't know enough from the webhook payload alone to update the
+		// affected media item gauges precisely, so invalidate the cache to
+		// force a full reconciliation on the next scrape instead of waiting
+		// for the poll-interval ticker.
+		c.forceRefresh.Store(true)
+	default:
+		slog.Warn("plex webhook: unhandled event", "event", payload.Event)
+	}
+}
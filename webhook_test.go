@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func postWebhook(t *testing.T, c *Collector, payload webhookPayload) *httptest.ResponseRecorder {
+	t.Helper()
+
+	raw, err := json.Marshal(payload)
+
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	if err := writer.WriteField("payload", string(raw)); err != nil {
+		t.Fatalf("write payload field: %v", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("close writer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/plex/webhook", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestWebhookMediaPlayAndStop(t *testing.T) {
+	c := NewCollector(&fakePlexAPI{}, CollectorConfig{Concurrency: 1})
+
+	play := webhookPayload{Event: "media.play"}
+	play.Account.Title = "alice"
+	play.Player.Title = "living room"
+	play.Metadata.Type = "movie"
+
+	if rec := postWebhook(t, c, play); rec.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	gauge := c.playbackSessionsActive.WithLabelValues("alice", "living room", "movie")
+
+	if got := testutil.ToFloat64(gauge); got != 1 {
+		t.Fatalf("got playbackSessionsActive %v, want 1", got)
+	}
+
+	stop := play
+	stop.Event = "media.stop"
+
+	if rec := postWebhook(t, c, stop); rec.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	// DeleteLabelValues removes the gauge entirely, so re-fetching the same
+	// label combination creates a fresh one defaulting to 0. If the delete
+	// hadn't happened, this would still read back the 1 set above.
+	gauge = c.playbackSessionsActive.WithLabelValues("alice", "living room", "movie")
+
+	if got := testutil.ToFloat64(gauge); got != 0 {
+		t.Fatalf("got playbackSessionsActive %v after stop, want 0", got)
+	}
+}
+
+func TestWebhookLibraryNewForcesRefresh(t *testing.T) {
+	c := NewCollector(&fakePlexAPI{}, CollectorConfig{Concurrency: 1})
+	c.lastScrape = time.Now()
+
+	payload := webhookPayload{Event: "library.new"}
+	payload.Metadata.LibrarySectionTitle = "Movies"
+
+	if rec := postWebhook(t, c, payload); rec.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	if !c.forceRefresh.Load() {
+		t.Fatal("expected forceRefresh to be set after a library.new event")
+	}
+}
+
+func TestWebhookRejectsNonPost(t *testing.T) {
+	c := NewCollector(&fakePlexAPI{}, CollectorConfig{Concurrency: 1})
+
+	req := httptest.NewRequest(http.MethodGet, "/plex/webhook", nil)
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("got status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}